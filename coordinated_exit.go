@@ -6,12 +6,14 @@
 package coordinated_exit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var shouldExit atomic.Bool
@@ -19,6 +21,46 @@ var exitReasons []error
 var signalAlreadyHandled bool
 var exitCond *sync.Cond
 var mutex sync.Mutex
+var exitContext context.Context
+var cancelExitContext context.CancelCauseFunc
+var exitContextOnce sync.Once
+var exitContextGoroutine sync.WaitGroup
+var exitCallbacks []exitCallback
+var runExitCallbacksOnce sync.Once
+var exitCode int
+var exitCodeSet bool
+
+// The function called with a single message when a registered exit
+// callback exceeds its timeout and is abandoned. Guarded by mutex, like the
+// rest of the package's shared mutable state; read and written only through
+// SetExitCallbackLogger and logExitCallbackTimeout.
+var exitCallbackLogger = func(message string) {
+	fmt.Fprintln(os.Stderr, message)
+}
+
+// Replaces the function called with a single message when a registered
+// exit callback exceeds its timeout and is abandoned. Defaults to printing
+// to os.Stderr; reassign it (for example) to route the message into a
+// structured logging library instead. Safe to call concurrently with exit
+// callbacks timing out.
+func SetExitCallbackLogger(logger func(message string)) {
+	(&mutex).Lock()
+	exitCallbackLogger = logger
+	(&mutex).Unlock()
+}
+
+// Invokes the current exit callback logger with message.
+func logExitCallbackTimeout(message string) {
+	(&mutex).Lock()
+	logger := exitCallbackLogger
+	(&mutex).Unlock()
+	logger(message)
+}
+
+type exitCallback struct {
+	fn      func()
+	timeout time.Duration
+}
 
 // Returns true if any routine or prior code as indicated that the program
 // should exit.
@@ -65,19 +107,171 @@ func ExitWithErrorf(format string, args ...any) {
 	ExitWithError(e)
 }
 
-// To be run in exactly one goroutine. Removes the signal handler and returns
-// when either os.Interrupt occurs or when the exit flag is set for any other
-// reason.
-func waitForInterruptRoutine() {
+// Explicitly sets the process exit code that Run, or a caller of
+// WaitForExit, should use when terminating the program. Overrides whatever
+// code ExitCode() would otherwise infer from ExitReason().
+func SetExitCode(code int) {
+	(&mutex).Lock()
+	exitCode = code
+	exitCodeSet = true
+	(&mutex).Unlock()
+}
+
+// Returns the process exit code previously passed to SetExitCode,
+// ExitWithCode, or ExitWithErrorAndCode. If none of those have been called,
+// returns 1 if ExitReason() is non-nil, or 0 otherwise.
+func ExitCode() int {
+	(&mutex).Lock()
+	set := exitCodeSet
+	code := exitCode
+	(&mutex).Unlock()
+	if set {
+		return code
+	}
+	if ExitReason() != nil {
+		return 1
+	}
+	return 0
+}
+
+// Equivalent to calling SetExitCode(code) followed by ExitWithoutError().
+func ExitWithCode(code int) {
+	SetExitCode(code)
+	ExitWithoutError()
+}
+
+// Equivalent to calling SetExitCode(code) followed by ExitWithError(e).
+func ExitWithErrorAndCode(e error, code int) {
+	SetExitCode(code)
+	ExitWithError(e)
+}
+
+// SignalExitPolicy controls what ExitOnSignals and ExitOnSignalsWithHandler
+// do about a repeated matching signal received after the package-wide exit
+// flag has already been set.
+type SignalExitPolicy int
+
+const (
+	// ForceExitAfterGracePeriod calls os.Exit(130) immediately if a repeat
+	// signal arrives within the grace period following the one that
+	// triggered the exit, without waiting for in-progress shutdown work
+	// (including callbacks registered via RegisterExitCallback) to finish.
+	// Once the grace period elapses, further repeats are ignored, the same
+	// as IgnoreAdditionalSignals (including the handler's removal once
+	// WaitForExit() returns). This is the usual behavior wanted by
+	// long-running daemons, where a second Ctrl+C means "stop waiting and
+	// kill it".
+	ForceExitAfterGracePeriod SignalExitPolicy = iota
+	// RestoreDefaultAfterFirstSignal uninstalls the signal handler as soon
+	// as the first matching signal has been handled, so a repeat falls back
+	// to the Go runtime's default behavior (normally, an immediate,
+	// uncatchable exit). This is how ExitOnInterrupt behaved before
+	// ExitOnSignals existed.
+	RestoreDefaultAfterFirstSignal
+	// IgnoreAdditionalSignals leaves the handler installed, but takes no
+	// special action in response to any signal received after the first.
+	// The handler is removed once WaitForExit() returns (that is, after any
+	// exit callbacks have finished or timed out); a signal arriving after
+	// that gets the Go runtime's default behavior.
+	IgnoreAdditionalSignals
+)
+
+// Indirection for os.Exit so tests can observe a force-exit decision without
+// actually killing the test process.
+var osExit = os.Exit
+
+// Computes what signalRoutine should do about a matching signal that
+// arrives after the package-wide exit flag has already been set (i.e. not
+// the signal that triggered the exit). deadlineArmed and forceExitDeadline
+// describe the force-exit grace window as last observed by the caller; now
+// is the current time, passed in rather than read directly so this stays a
+// pure, trivially-testable function.
+func decideRepeatSignalAction(policy SignalExitPolicy, gracePeriod time.Duration,
+	deadlineArmed bool, forceExitDeadline, now time.Time) (forceExit, stopHandling bool) {
+	switch policy {
+	case RestoreDefaultAfterFirstSignal:
+		return false, true
+	case ForceExitAfterGracePeriod:
+		if !deadlineArmed {
+			// The exit flag is already set, but nothing has armed a grace
+			// deadline yet (the watcher goroutine below hasn't caught up).
+			// Wait for the next repeat rather than silently never firing.
+			return false, false
+		}
+		return gracePeriod <= 0 || now.Before(forceExitDeadline), false
+	default: // IgnoreAdditionalSignals
+		return false, false
+	}
+}
+
+// To be run in exactly one goroutine. Watches for any of the given signals,
+// invoking handler (or, if handler is nil, ExitWithoutError) the first time
+// one arrives. Once the package-wide exit flag has been set, applies policy
+// to any further matching signal, using gracePeriod where policy is
+// ForceExitAfterGracePeriod. Returns, removing the handler, once
+// WaitForExit() itself returns.
+func signalRoutine(signals []os.Signal, handler func(os.Signal) error,
+	policy SignalExitPolicy, gracePeriod time.Duration) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, signals...)
 
+	var deadlineMu sync.Mutex
+	var forceExitDeadline time.Time
+	var deadlineArmed bool
+	arm := func() {
+		deadlineMu.Lock()
+		if !deadlineArmed {
+			forceExitDeadline = time.Now().Add(gracePeriod)
+			deadlineArmed = true
+		}
+		deadlineMu.Unlock()
+	}
+
+	// The exit flag can be set by something entirely unrelated to this
+	// signal handler -- a failed Group worker, a RegisterExitCallback error,
+	// any other ExitWithError/ExitWithoutError call. This watcher arms the
+	// force-exit deadline as soon as that happens, so a repeat signal is
+	// always judged against a real grace window instead of a deadline that
+	// was only ever set from signals this routine itself observed.
 	go func() {
-		// In a child routine, wait for the signal and set the exit flag when
-		// it occurs or when c is closed. Either way, it's safe to call
-		// ExitWithoutError()
-		<-c
-		ExitWithoutError()
+		waitForExitFlag()
+		arm()
+		if policy == RestoreDefaultAfterFirstSignal {
+			signal.Stop(c)
+		}
+	}()
+
+	go func() {
+		for sig := range c {
+			if !(&shouldExit).Load() {
+				if handler != nil {
+					if e := handler(sig); e != nil {
+						ExitWithError(e)
+					}
+				} else {
+					ExitWithoutError()
+				}
+				// A handler may leave the program running (e.g. on SIGHUP);
+				// only a signal that set the exit flag starts the grace window.
+				if (&shouldExit).Load() {
+					arm()
+				}
+				continue
+			}
+
+			deadlineMu.Lock()
+			armed, deadline := deadlineArmed, forceExitDeadline
+			deadlineMu.Unlock()
+			forceExit, stop := decideRepeatSignalAction(policy, gracePeriod, armed,
+				deadline, time.Now())
+			if stop {
+				signal.Stop(c)
+				return
+			}
+			if forceExit {
+				osExit(130)
+			}
+		}
 	}()
 
 	// The parent routine waits for exit simply to uninstall the signal handler
@@ -87,22 +281,134 @@ func waitForInterruptRoutine() {
 	close(c)
 }
 
+// Call this prior to WaitForExit() in order to set up handlers for the given
+// signals. The first matching signal received is equivalent to
+// ExitWithoutError() being called, and causes WaitForExit() to return. Any
+// signal received after that is handled according to policy: see
+// ForceExitAfterGracePeriod, RestoreDefaultAfterFirstSignal, and
+// IgnoreAdditionalSignals. gracePeriod is only used by
+// ForceExitAfterGracePeriod, and is ignored by the other two policies.
+//
+// Only one call, across ExitOnSignals, ExitOnSignalsWithHandler, and
+// ExitOnInterrupt, will actually install a handler; later calls are no-ops.
+func ExitOnSignals(policy SignalExitPolicy, gracePeriod time.Duration, signals ...os.Signal) {
+	(&mutex).Lock()
+	if signalAlreadyHandled {
+		(&mutex).Unlock()
+		return
+	}
+	signalAlreadyHandled = true
+	(&mutex).Unlock()
+
+	go signalRoutine(signals, nil, policy, gracePeriod)
+}
+
+// Like ExitOnSignals, but calls handler for every matching signal instead of
+// unconditionally calling ExitWithoutError(). This lets a program
+// distinguish between, for example, SIGTERM (exit), SIGHUP (reload
+// configuration), and SIGQUIT (dump goroutines and exit gracefully):
+// returning a non-nil error from handler is equivalent to passing it to
+// ExitWithError, while returning nil leaves the program running (handler may
+// still choose to call ExitWithoutError() itself, e.g. after dumping
+// goroutines). Once the exit flag has been set by any means, policy and
+// gracePeriod govern further matching signals exactly as in ExitOnSignals.
+func ExitOnSignalsWithHandler(handler func(os.Signal) error, policy SignalExitPolicy,
+	gracePeriod time.Duration, signals ...os.Signal) {
+	(&mutex).Lock()
+	if signalAlreadyHandled {
+		(&mutex).Unlock()
+		return
+	}
+	signalAlreadyHandled = true
+	(&mutex).Unlock()
+
+	go signalRoutine(signals, handler, policy, gracePeriod)
+}
+
 // Call this prior to WaitForExit() in order to set up handlers for
 // os.Interrupt. If an os.Interrupt occurs, it will be equivalent to
 // ExitWithoutError() being called, and cause WaitForExit() to return. The
 // signal handler will be removed if ExitWithError() or ExitWithoutError() is
 // called from any other context.
+//
+// Equivalent to ExitOnSignals(RestoreDefaultAfterFirstSignal, 0,
+// os.Interrupt); kept for backwards compatibility. New code should prefer
+// ExitOnSignals.
 func ExitOnInterrupt() {
+	ExitOnSignals(RestoreDefaultAfterFirstSignal, 0, os.Interrupt)
+}
+
+// Registers fn to be run in its own goroutine once the package-wide exit
+// flag is set, before WaitForExit() returns to any caller. This mirrors the
+// atexit pattern, giving callers a place to flush logs, close DB
+// connections, or drain queues without wiring every worker manually.
+// WaitForExit() waits for all registered callbacks to finish, up to the
+// largest timeout among all currently-registered callbacks; callbacks still
+// running after that are abandoned (left running in the background) and
+// reported via the logger set with SetExitCallbackLogger. A callback that
+// panics is recovered, and the panic is converted to an error appended to
+// the list returned by ExitReason().
+func RegisterExitCallback(fn func(), timeout time.Duration) {
 	(&mutex).Lock()
-	if signalAlreadyHandled {
-		// We already have a goroutine waiting for the interrupt.
-		(&mutex).Unlock()
+	exitCallbacks = append(exitCallbacks, exitCallback{fn: fn, timeout: timeout})
+	(&mutex).Unlock()
+}
+
+// Runs every callback registered via RegisterExitCallback concurrently, and
+// waits for them up to the largest registered timeout. Meant to be run
+// exactly once, via runExitCallbacksOnce.
+func runExitCallbacks() {
+	(&mutex).Lock()
+	callbacks := exitCallbacks
+	(&mutex).Unlock()
+	if len(callbacks) == 0 {
 		return
 	}
 
-	go waitForInterruptRoutine()
-	signalAlreadyHandled = true
-	(&mutex).Unlock()
+	maxTimeout := time.Duration(0)
+	var wg sync.WaitGroup
+	for _, cb := range callbacks {
+		if cb.timeout > maxTimeout {
+			maxTimeout = cb.timeout
+		}
+		wg.Add(1)
+		go func(cb exitCallback) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					(&mutex).Lock()
+					exitReasons = append(exitReasons,
+						fmt.Errorf("exit callback panicked: %v", r))
+					(&mutex).Unlock()
+				}
+			}()
+			cb.fn()
+		}(cb)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(maxTimeout):
+		logExitCallbackTimeout(fmt.Sprintf("coordinated_exit: timed out after %s "+
+			"waiting for exit callbacks to finish; abandoning them", maxTimeout))
+	}
+}
+
+// Blocks until the package-wide exit flag has been set, without running any
+// callbacks registered via RegisterExitCallback. Exists so that code which
+// only cares about the exit flag itself (such as Context()'s cancellation)
+// doesn't have its signal gated behind unrelated cleanup work.
+func waitForExitFlag() {
+	exitCond.L.Lock()
+	for !(&shouldExit).Load() {
+		exitCond.Wait()
+	}
+	exitCond.L.Unlock()
 }
 
 // Blocks until one of the following has occurred:
@@ -112,16 +418,89 @@ func ExitOnInterrupt() {
 //     called.
 //
 // This is safe to call from multiple goroutines, or multiple times. It will
-// immediately return if the exit has already been signalled. Returns any error
-// passed to ExitWithError(...). If ExitWithError was called more than once,
-// this will use errors.Join to combine them.
-func WaitForExit() error {
-	exitCond.L.Lock()
-	for !(&shouldExit).Load() {
-		exitCond.Wait()
+// immediately return if the exit has already been signalled. Before
+// returning for the first time, it runs any callbacks registered via
+// RegisterExitCallback() and waits for them as described there. Returns any
+// error passed to ExitWithError(...), including errors produced by panicking
+// callbacks (using errors.Join if more than one has been set), along with
+// the winning exit code as would be returned by ExitCode().
+func WaitForExit() (error, int) {
+	waitForExitFlag()
+	runExitCallbacksOnce.Do(runExitCallbacks)
+	return ExitReason(), ExitCode()
+}
+
+// Invokes main, and if it returns a non-nil error, passes it to
+// ExitWithError so that it becomes the program's exit reason. Either way,
+// Run then blocks on WaitForExit so that any other goroutine (a signal
+// handler installed via ExitOnSignals, another worker, ...) gets a chance to
+// request a shutdown and run its exit callbacks, prints the resulting error
+// to stderr if there is one, and terminates the process via os.Exit with
+// the resulting ExitCode(). Intended to be the last call in a program's
+// func main():
+//
+//	func main() {
+//		coordinated_exit.ExitOnSignals(coordinated_exit.ForceExitAfterGracePeriod,
+//			5*time.Second, syscall.SIGINT, syscall.SIGTERM)
+//		coordinated_exit.Run(func() error {
+//			// Start workers, set up servers, etc., and return an error only
+//			// if setup itself failed.
+//			return nil
+//		})
+//	}
+func Run(main func() error) {
+	if e := main(); e != nil {
+		ExitWithError(e)
+	}
+	e, code := WaitForExit()
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	os.Exit(code)
+}
+
+// Returns a context.Context that is canceled as soon as the package-wide
+// exit flag is set, with context.Cause(ctx) equal to ExitReason() as it was at
+// the moment of cancellation (context.Canceled if no error had been set by
+// then; errors added afterwards are not reflected). This lets
+// callers plug into APIs that expect a context (net/http, database drivers,
+// errgroup, ...) instead of polling ShouldExit() in a loop. The first call
+// lazily starts a single goroutine that waits for the exit condition and
+// cancels the context; subsequent calls reuse the same context and
+// goroutine, so the cost is independent of how many callers request it.
+// Cancellation is not delayed by callbacks registered via
+// RegisterExitCallback: callers see ctx.Done() fire immediately, so they can
+// stop in-flight work before unrelated cleanup callbacks run.
+func Context() context.Context {
+	exitContextOnce.Do(func() {
+		exitContext, cancelExitContext = context.WithCancelCause(context.Background())
+		exitContextGoroutine.Add(1)
+		go func() {
+			defer exitContextGoroutine.Done()
+			waitForExitFlag()
+			cancelExitContext(ExitReason())
+		}()
+	})
+	return exitContext
+}
+
+// Returns as soon as either ctx is canceled, in which case it returns
+// ctx.Err(), or the package-wide exit flag is set, in which case it returns
+// ExitReason(). Does not itself set the package-wide exit flag. Unlike
+// WaitForExit, does not wait for callbacks registered via
+// RegisterExitCallback to finish, since it reuses Context()'s single,
+// immediately-canceled goroutine rather than starting a new one per call;
+// this keeps WaitForExitContext safe to call from many short-lived
+// goroutines (e.g. once per incoming request) without leaking one parked
+// goroutine per call.
+func WaitForExitContext(ctx context.Context) error {
+	exitCtx := Context()
+	select {
+	case <-exitCtx.Done():
+		return ExitReason()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	exitCond.L.Unlock()
-	return ExitReason()
 }
 
 func init() {
@@ -0,0 +1,228 @@
+package coordinated_exit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetForTesting restores all package-level state to what init() produces,
+// so each test can start from a clean slate despite the package's
+// documented "no resetting" behavior for real callers. This must only be
+// used from tests.
+func resetForTesting() {
+	// Unblock any goroutine left over from a previous test that's still
+	// waiting on the current exitCond (e.g. Context()'s background
+	// goroutine), and wait for it to actually finish, before swapping
+	// mutex/exitCond out from under it.
+	ExitWithoutError()
+	exitContextGoroutine.Wait()
+
+	shouldExit.Store(false)
+	exitReasons = make([]error, 0, 16)
+	signalAlreadyHandled = false
+	mutex = sync.Mutex{}
+	exitCond = sync.NewCond(&mutex)
+	exitContext = nil
+	cancelExitContext = nil
+	exitContextOnce = sync.Once{}
+	exitCallbacks = nil
+	runExitCallbacksOnce = sync.Once{}
+	exitCode = 0
+	exitCodeSet = false
+	exitCallbackLogger = func(message string) { fmt.Fprintln(os.Stderr, message) }
+}
+
+func TestRegisterExitCallbackRunsBeforeWaitForExitReturns(t *testing.T) {
+	resetForTesting()
+	var ran atomic.Bool
+	RegisterExitCallback(func() { ran.Store(true) }, time.Second)
+
+	ExitWithoutError()
+	WaitForExit()
+
+	if !ran.Load() {
+		t.Fatal("expected the registered callback to have run")
+	}
+}
+
+func TestExitCallbackPanicIsRecoveredAndReported(t *testing.T) {
+	resetForTesting()
+	RegisterExitCallback(func() { panic("boom") }, time.Second)
+
+	ExitWithoutError()
+	e, _ := WaitForExit()
+
+	if e == nil || !strings.Contains(e.Error(), "boom") {
+		t.Fatalf("expected the panic to be converted into an error, got %v", e)
+	}
+}
+
+func TestExitCallbackExceedingTimeoutIsAbandoned(t *testing.T) {
+	resetForTesting()
+	var logged atomic.Bool
+	SetExitCallbackLogger(func(string) { logged.Store(true) })
+
+	block := make(chan struct{})
+	defer close(block)
+	RegisterExitCallback(func() { <-block }, 20*time.Millisecond)
+
+	ExitWithoutError()
+	start := time.Now()
+	WaitForExit()
+	elapsed := time.Since(start)
+
+	if elapsed > time.Second {
+		t.Fatalf("WaitForExit waited %s for an abandoned callback", elapsed)
+	}
+	if !logged.Load() {
+		t.Fatal("expected the exit callback logger to be called for the abandoned callback")
+	}
+}
+
+func TestContextCancellationIsNotDelayedByCallbacks(t *testing.T) {
+	resetForTesting()
+	block := make(chan struct{})
+	defer close(block)
+	RegisterExitCallback(func() { <-block }, time.Hour)
+
+	ctx := Context()
+	ExitWithoutError()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected ctx.Done() to fire without waiting for a slow exit callback")
+	}
+}
+
+func TestWaitForExitContextReturnsCtxErrOnCancellation(t *testing.T) {
+	resetForTesting()
+	// This test never sets the package-wide exit flag itself, so without
+	// this, the goroutine Context() starts internally would be left
+	// permanently blocked on exitCond.
+	t.Cleanup(ExitWithoutError)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if e := WaitForExitContext(ctx); e != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", e)
+	}
+}
+
+func TestWaitForExitContextReturnsExitReason(t *testing.T) {
+	resetForTesting()
+	ExitWithErrorf("kaboom")
+
+	if e := WaitForExitContext(context.Background()); e == nil ||
+		!strings.Contains(e.Error(), "kaboom") {
+		t.Fatalf("expected the package's exit reason, got %v", e)
+	}
+}
+
+func TestWaitForExitContextDoesNotLeakGoroutinesPerCall(t *testing.T) {
+	resetForTesting()
+	// This test never sets the package-wide exit flag itself, so without
+	// this, the goroutine Context() starts internally would be left
+	// permanently blocked on exitCond.
+	t.Cleanup(ExitWithoutError)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		WaitForExitContext(ctx)
+	}
+
+	// Give any stray goroutines a chance to actually exit before sampling.
+	runtime.Gosched()
+	time.Sleep(10 * time.Millisecond)
+	after := runtime.NumGoroutine()
+
+	if after > before+5 {
+		t.Fatalf("goroutine count grew from %d to %d after 50 calls; "+
+			"WaitForExitContext appears to leak a goroutine per call", before, after)
+	}
+}
+
+func TestDecideRepeatSignalAction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	future := now.Add(time.Minute)
+	past := now.Add(-time.Minute)
+
+	cases := []struct {
+		name              string
+		policy            SignalExitPolicy
+		gracePeriod       time.Duration
+		deadlineArmed     bool
+		forceExitDeadline time.Time
+		wantForceExit     bool
+		wantStop          bool
+	}{
+		{
+			name:     "restore default always stops, regardless of arming",
+			policy:   RestoreDefaultAfterFirstSignal,
+			wantStop: true,
+		},
+		{
+			name:   "ignore additional signals never forces exit or stops",
+			policy: IgnoreAdditionalSignals,
+		},
+		{
+			name:          "force exit policy but deadline not yet armed does nothing",
+			policy:        ForceExitAfterGracePeriod,
+			gracePeriod:   time.Minute,
+			deadlineArmed: false,
+		},
+		{
+			name:              "force exit policy within armed grace window fires",
+			policy:            ForceExitAfterGracePeriod,
+			gracePeriod:       time.Minute,
+			deadlineArmed:     true,
+			forceExitDeadline: future,
+			wantForceExit:     true,
+		},
+		{
+			name:              "force exit policy after armed grace window elapses does nothing",
+			policy:            ForceExitAfterGracePeriod,
+			gracePeriod:       time.Minute,
+			deadlineArmed:     true,
+			forceExitDeadline: past,
+		},
+		{
+			name:          "force exit policy with zero grace period always fires once armed",
+			policy:        ForceExitAfterGracePeriod,
+			gracePeriod:   0,
+			deadlineArmed: true,
+			// forceExitDeadline left at its zero value on purpose: a zero
+			// grace period means "always force exit", so the deadline
+			// itself shouldn't matter.
+			wantForceExit: true,
+		},
+		{
+			name:              "exit already set by something else before any signal still honors the grace window",
+			policy:            ForceExitAfterGracePeriod,
+			gracePeriod:       time.Minute,
+			deadlineArmed:     true,
+			forceExitDeadline: future,
+			wantForceExit:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			forceExit, stop := decideRepeatSignalAction(c.policy, c.gracePeriod,
+				c.deadlineArmed, c.forceExitDeadline, now)
+			if forceExit != c.wantForceExit || stop != c.wantStop {
+				t.Fatalf("decideRepeatSignalAction() = (%v, %v), want (%v, %v)",
+					forceExit, stop, c.wantForceExit, c.wantStop)
+			}
+		})
+	}
+}
@@ -44,7 +44,7 @@ func main() {
 	}
 
 	fmt.Printf("Main routine waiting for exit...\n")
-	e := coordinated_exit.WaitForExit()
+	e, _ := coordinated_exit.WaitForExit()
 	if e != nil {
 		fmt.Printf("WaitForExit() returned error %s\n", e)
 	} else {
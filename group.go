@@ -0,0 +1,83 @@
+package coordinated_exit
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// A Group tracks a set of named worker goroutines started via Go. It
+// recovers panics and attributes each worker's error (or panic) to the name
+// it was started with, so callers can tell *which* goroutine failed instead
+// of only that something did. The first worker to fail calls ExitWithError,
+// signalling every other worker through the package-wide exit flag so it
+// can wind down cleanly instead of a single crash taking down the process
+// before its siblings get a chance to clean up.
+type Group struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	errors map[string]error
+}
+
+// Returns a new, empty Group, ready to have workers added via Go.
+func NewGroup() *Group {
+	return &Group{errors: make(map[string]error)}
+}
+
+// Starts fn in its own goroutine, tracked under name. name is used to
+// attribute fn's error (or panic) in the map returned by Wait, so it should
+// be unique within the Group; if two workers share a name, the one that
+// fails last wins that entry. If fn panics, the panic is recovered and
+// converted into an error carrying the stack trace at the point of the
+// panic. If fn returns a non-nil error, or panics, that error is recorded
+// for name and ExitWithError is called with an error identifying the
+// failing worker, causing ShouldExit() (and Context()) to report true for
+// every other goroutine in the program.
+func (g *Group) Go(name string, fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err, panicked := g.runRecovered(name, fn)
+		if err == nil {
+			return
+		}
+		g.mu.Lock()
+		g.errors[name] = err
+		g.mu.Unlock()
+		if !panicked {
+			// A panic's error already names the worker.
+			err = fmt.Errorf("worker %q failed: %w", name, err)
+		}
+		ExitWithError(err)
+	}()
+}
+
+// Runs fn, recovering any panic and converting it into an error tagged with
+// name and fn's stack trace at the time of the panic. panicked reports
+// whether fn panicked.
+func (g *Group) runRecovered(name string, fn func() error) (err error, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("worker %q panicked: %v\n%s", name, r, debug.Stack())
+			panicked = true
+		}
+	}()
+	return fn(), false
+}
+
+// Blocks until every worker started via Go has returned, then returns a map
+// from worker name to the error it produced. Workers that returned nil are
+// omitted, so a nil or empty map means every worker finished without error.
+func (g *Group) Wait() map[string]error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.errors) == 0 {
+		return nil
+	}
+	result := make(map[string]error, len(g.errors))
+	for name, err := range g.errors {
+		result[name] = err
+	}
+	return result
+}
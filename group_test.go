@@ -0,0 +1,59 @@
+package coordinated_exit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGroupAttributesErrorsByWorkerName(t *testing.T) {
+	resetForTesting()
+	g := NewGroup()
+	g.Go("ok-worker", func() error { return nil })
+	g.Go("failing-worker", func() error { return errors.New("boom") })
+
+	errs := g.Wait()
+
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one recorded error, got %d: %v", len(errs), errs)
+	}
+	if e := errs["failing-worker"]; e == nil || e.Error() != "boom" {
+		t.Fatalf("expected failing-worker's error to be recorded, got %v", errs)
+	}
+	if !ShouldExit() {
+		t.Fatal("expected a failing worker to trigger the shared exit flag")
+	}
+}
+
+func TestGroupRecoversPanicAndAttributesItByName(t *testing.T) {
+	resetForTesting()
+	g := NewGroup()
+	g.Go("panicky-worker", func() error { panic("kaboom") })
+
+	errs := g.Wait()
+
+	e := errs["panicky-worker"]
+	if e == nil {
+		t.Fatal("expected the panic to be recorded against panicky-worker")
+	}
+	if !strings.Contains(e.Error(), "panicky-worker") || !strings.Contains(e.Error(), "kaboom") {
+		t.Fatalf("expected the error to name the worker and the panic value, got %v", e)
+	}
+	if !ShouldExit() {
+		t.Fatal("expected a panicking worker to trigger the shared exit flag")
+	}
+}
+
+func TestGroupWaitReturnsNilWhenNoWorkerFails(t *testing.T) {
+	resetForTesting()
+	g := NewGroup()
+	g.Go("a", func() error { return nil })
+	g.Go("b", func() error { return nil })
+
+	if errs := g.Wait(); errs != nil {
+		t.Fatalf("expected a nil error map, got %v", errs)
+	}
+	if ShouldExit() {
+		t.Fatal("expected the exit flag to remain unset when no worker fails")
+	}
+}